@@ -0,0 +1,215 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// Exporter serves a Prometheus /metrics endpoint derived from
+// StatsWorker/AlarmWorker and, optionally, forwards alarm transitions to
+// an Alertmanager webhook.
+type Exporter struct {
+	listenAddress   string
+	webConfigFile   string
+	alertmanagerURL string
+	logger          Logger
+
+	hitsTotal      *prometheus.CounterVec
+	sectionRate    *prometheus.GaugeVec
+	alarmTriggered prometheus.Gauge
+
+	client *http.Client
+}
+
+// Returns a new instance of Exporter. alertmanagerURL may be empty to
+// disable webhook forwarding.
+func NewExporter(listenAddress, webConfigFile, alertmanagerURL string, logger Logger) *Exporter {
+	e := &Exporter{
+		listenAddress:   listenAddress,
+		webConfigFile:   webConfigFile,
+		alertmanagerURL: alertmanagerURL,
+		logger:          logger,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		hitsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_log_hits_total",
+			Help: "Total number of HTTP hits observed, by section and status class.",
+		}, []string{"section", "status_class"}),
+		sectionRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_log_section_hit_rate",
+			Help: "Per-section hit rate (hits/sec) over the last stats interval.",
+		}, []string{"section"}),
+		alarmTriggered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_log_alarm_triggered",
+			Help: "Whether the traffic alarm is currently triggered (1) or not (0).",
+		}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e.hitsTotal, e.sectionRate, e.alarmTriggered)
+	registry.MustRegister(prometheus.NewGoCollector())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	e.watchWebConfig()
+
+	go func() {
+		server := &http.Server{Addr: e.listenAddress, Handler: mux}
+		flags := &web.FlagConfig{WebListenAddresses: &[]string{e.listenAddress}, WebConfigFile: &e.webConfigFile}
+		if err := web.ListenAndServe(server, flags, logStdlibAdapter{e.logger}); err != nil {
+			e.logger.Println(err)
+		}
+	}()
+
+	return e
+}
+
+// watchWebConfig validates -web.config.file at startup and re-validates
+// it whenever the process receives SIGHUP, matching the config reload
+// behavior exporters built on the node_exporter https package offer.
+func (e *Exporter) watchWebConfig() {
+	if e.webConfigFile == "" {
+		return
+	}
+	if err := web.Validate(e.webConfigFile); err != nil {
+		e.logger.Fatalln(err)
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := web.Validate(e.webConfigFile); err != nil {
+				e.logger.Println("invalid web config file, keeping previous configuration:", err)
+				continue
+			}
+			e.logger.Println("reloaded", e.webConfigFile)
+		}
+	}()
+}
+
+// Run consumes snapshots and alarm events until done is closed,
+// updating the exported metrics and forwarding alarm transitions to
+// Alertmanager.
+func (e *Exporter) Run(stats <-chan StatsSnapshot, alarms <-chan AlarmEvent, done <-chan struct{}) {
+	for {
+		select {
+		case snap, ok := <-stats:
+			if !ok {
+				return
+			}
+			e.observeStats(snap)
+		case event, ok := <-alarms:
+			if !ok {
+				return
+			}
+			e.observeAlarm(event)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (e *Exporter) observeStats(snap StatsSnapshot) {
+	for section, hits := range snap.SectionHits {
+		e.sectionRate.WithLabelValues(section).Set(float64(hits) / float64(snap.Interval))
+	}
+	for section, statusHits := range snap.SectionStatusHits {
+		for class, hits := range statusHits {
+			e.hitsTotal.WithLabelValues(section, statusClassLabel(class)).Add(float64(hits))
+		}
+	}
+}
+
+// statusClassLabel turns a status-class index (0 for "other", 1-5 for
+// the 1xx-5xx classes, matching StatsSnapshot.StatusHits) into the
+// metric label value.
+func statusClassLabel(class int) string {
+	if class > 0 {
+		return fmt.Sprintf("%dxx", class)
+	}
+	return "other"
+}
+
+func (e *Exporter) observeAlarm(event AlarmEvent) {
+	if event.Triggered {
+		e.alarmTriggered.Set(1)
+	} else {
+		e.alarmTriggered.Set(0)
+	}
+	if e.alertmanagerURL != "" {
+		if err := e.notifyAlertmanager(event); err != nil {
+			e.logger.Println(err)
+		}
+	}
+}
+
+// amAlert is a single entry of the Alertmanager v2 webhook payload.
+type amAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    string            `json:"startsAt,omitempty"`
+	EndsAt      string            `json:"endsAt,omitempty"`
+}
+
+func (e *Exporter) notifyAlertmanager(event AlarmEvent) error {
+	alert := amAlert{
+		Labels: map[string]string{
+			"alertname": "HighTraffic",
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("hits EWMA = %.2f", event.EMA),
+		},
+	}
+	if event.Triggered {
+		alert.StartsAt = event.At.Format(time.RFC3339)
+	} else {
+		alert.EndsAt = event.At.Format(time.RFC3339)
+	}
+
+	body, err := json.Marshal([]amAlert{alert})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.alertmanagerURL+"/api/v2/alerts", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alertmanager webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// logStdlibAdapter adapts Logger to the go-kit Logger expected by
+// github.com/prometheus/exporter-toolkit/web.
+type logStdlibAdapter struct {
+	logger Logger
+}
+
+func (l logStdlibAdapter) Log(keyvals ...interface{}) error {
+	l.logger.Println(keyvals...)
+	return nil
+}
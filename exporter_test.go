@@ -0,0 +1,107 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestExporter builds an Exporter with its metrics registered but
+// without starting the /metrics HTTP server, so observeStats/
+// observeAlarm can be exercised directly.
+func newTestExporter() *Exporter {
+	return &Exporter{
+		client: &http.Client{Timeout: time.Second},
+		hitsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_log_hits_total",
+			Help: "test",
+		}, []string{"section", "status_class"}),
+		sectionRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_log_section_hit_rate",
+			Help: "test",
+		}, []string{"section"}),
+		alarmTriggered: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_log_alarm_triggered",
+			Help: "test",
+		}),
+	}
+}
+
+func TestObserveStatsPerSectionLabels(t *testing.T) {
+	e := newTestExporter()
+	e.observeStats(StatsSnapshot{
+		Interval:    10,
+		SectionHits: map[string]int{"api": 20},
+		SectionStatusHits: map[string][]int{
+			"api": {0, 0, 18, 0, 2, 0},
+		},
+	})
+
+	if got := testutil.ToFloat64(e.hitsTotal.WithLabelValues("api", "2xx")); got != 18 {
+		t.Fatalf("expected 18 hits for api/2xx, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.hitsTotal.WithLabelValues("api", "4xx")); got != 2 {
+		t.Fatalf("expected 2 hits for api/4xx, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.sectionRate.WithLabelValues("api")); got != 2 {
+		t.Fatalf("expected section rate 2, got %v", got)
+	}
+}
+
+func TestStatusClassLabel(t *testing.T) {
+	cases := map[int]string{0: "other", 1: "1xx", 4: "4xx"}
+	for class, want := range cases {
+		if got := statusClassLabel(class); got != want {
+			t.Fatalf("statusClassLabel(%d) = %q, want %q", class, got, want)
+		}
+	}
+}
+
+func TestNotifyAlertmanager(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	e := newTestExporter()
+	e.alertmanagerURL = server.URL
+
+	event := AlarmEvent{Triggered: true, EMA: 42, At: time.Now()}
+	if err := e.notifyAlertmanager(event); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/api/v2/alerts" {
+		t.Fatalf("expected POST to /api/v2/alerts, got %s", gotPath)
+	}
+}
+
+func TestNotifyAlertmanagerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	e := newTestExporter()
+	e.alertmanagerURL = server.URL
+
+	if err := e.notifyAlertmanager(AlarmEvent{}); err == nil {
+		t.Fatal("expected an error for a non-2xx response")
+	}
+}
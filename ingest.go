@@ -0,0 +1,140 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runSources starts one ingestion goroutine per source matched by
+// patterns (a comma-separated list of file paths, glob patterns, or
+// "-" for stdin), multiplexing every Hit they produce onto out.
+// Every pattern is re-scanned every rescanInterval (sources already
+// started are skipped) so that files created after startup (eg a fresh
+// /var/log/nginx/*access.log) are picked up automatically; a
+// rescanInterval <= 0 disables rescanning.
+func runSources(patterns []string, out chan<- *Hit, done <-chan struct{}, newParser func() (Parser, error), rescanInterval time.Duration, logger Logger) {
+	started := make(map[string]bool)
+
+	start := func(source string) {
+		if started[source] {
+			return
+		}
+
+		parser, err := newParser()
+		if err != nil {
+			logger.Fatalln(err)
+		}
+
+		if source == "-" {
+			started[source] = true
+			go runStdin(out, done, parser, logger)
+			return
+		}
+
+		processor, err := NewLogProcessor(source, parser, logger)
+		if err != nil {
+			// the file may not exist yet, eg a glob that hasn't
+			// matched anything so far: leave it unmarked so the next
+			// rescan retries it
+			logger.Println(err)
+			return
+		}
+		started[source] = true
+		go func() {
+			if err := processor.Run(out, done); err != nil {
+				logger.Println(err)
+			}
+		}()
+	}
+
+	scan := func() {
+		for _, pattern := range patterns {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			if pattern == "-" {
+				start("-")
+				continue
+			}
+
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				logger.Println(err)
+				continue
+			}
+			if len(matches) == 0 {
+				// not a glob, or a glob with no match yet
+				start(pattern)
+				continue
+			}
+			for _, match := range matches {
+				start(match)
+			}
+		}
+	}
+
+	scan()
+	if rescanInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(rescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			scan()
+		case <-done:
+			return
+		}
+	}
+}
+
+// runStdin reads log lines from os.Stdin, used when "-" is given as a
+// -f source.
+func runStdin(out chan<- *Hit, done <-chan struct{}, parser Parser, logger Logger) {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			logger.Println(err)
+		}
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			hit, err := parser.Parse(line)
+			if err != nil {
+				logger.Println(err)
+				continue
+			}
+			hit.source = "-"
+			out <- hit
+		case <-done:
+			return
+		}
+	}
+}
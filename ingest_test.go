@@ -0,0 +1,74 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunSourcesGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingest_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file1 := filepath.Join(dir, "one.log")
+	file2 := filepath.Join(dir, "two.log")
+	for _, name := range []string{file1, file2} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	out := make(chan *Hit, 10)
+	done := make(chan struct{})
+	defer close(done)
+
+	newParser := func() (Parser, error) { return NewCLFParser(), nil }
+	go runSources([]string{filepath.Join(dir, "*.log")}, out, done, newParser, 0, logger)
+
+	// let the tail goroutines start and seek to EOF before appending,
+	// since NewLogProcessor only follows lines written after it starts
+	time.Sleep(200 * time.Millisecond)
+
+	line := `127.0.0.1 - - [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123` + "\n"
+	for _, name := range []string{file1, file2} {
+		f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	sources := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case hit := <-out:
+			sources[hit.source] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a hit from each matched file")
+		}
+	}
+	if !sources[file1] || !sources[file2] {
+		t.Fatalf("expected hits from %s and %s, got %v", file1, file2, sources)
+	}
+}
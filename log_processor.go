@@ -13,24 +13,26 @@ package main
 
 import (
 	"os"
-	"regexp"
-	"strconv"
-	"time"
 
 	"github.com/hpcloud/tail"
 )
 
 // LogProcessor watches a file stream
 type LogProcessor struct {
+	// source label stamped on every Hit read from this file, so
+	// downstream workers can break stats down per source
+	source string
 	logger Logger
+	parser Parser
 	stream *tail.Tail
 }
 
 // Returns a new instance of LogProcessor
-func NewLogProcessor(filename string, logger Logger) (*LogProcessor, error) {
+func NewLogProcessor(filename string, parser Parser, logger Logger) (*LogProcessor, error) {
 	// Skip directly to the end of the file to avoid processing old lines
 	tailConfig := tail.Config{
 		Follow:    true,
+		ReOpen:    true,
 		Logger:    tail.DiscardingLogger,
 		Location:  &tail.SeekInfo{Offset: 0, Whence: os.SEEK_END},
 		MustExist: true,
@@ -39,34 +41,23 @@ func NewLogProcessor(filename string, logger Logger) (*LogProcessor, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &LogProcessor{stream: stream, logger: logger}, nil
+	return &LogProcessor{source: filename, stream: stream, parser: parser, logger: logger}, nil
 }
 
 // Reads the HTTP log lines and sends Hit values to the out channel
 func (l *LogProcessor) Run(out chan<- *Hit, done <-chan struct{}) error {
 	defer l.stream.Cleanup()
-	clf := regexp.MustCompile("\\[([^]]+)\\] \"(\\S+) (\\S+) [^\"]+\" (\\d+)")
 
 	for {
 		select {
 		case line := <-l.stream.Lines:
-			matches := clf.FindStringSubmatch(line.Text)
-			if matches == nil {
-				l.logger.Printf("no match found for %s", line.Text)
-				continue
-			}
-			status, _ := strconv.Atoi(matches[4])
-			timestamp, err := time.Parse("02/Jan/2006:15:04:05 -0700", matches[1])
+			hit, err := l.parser.Parse(line.Text)
 			if err != nil {
 				l.logger.Println(err)
 				continue
 			}
-			out <- &Hit{
-				timestamp: timestamp,
-				uri:       matches[3],
-				method:    matches[2],
-				status:    status,
-			}
+			hit.source = l.source
+			out <- hit
 		case <-done:
 			return nil
 		}
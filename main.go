@@ -13,9 +13,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
+
+	"github.com/simonpasquier/http_log_console/pkg/tsdb"
 )
 
 // Hit represents a user's hit
@@ -28,6 +32,17 @@ type Hit struct {
 	uri string
 	// HTTP status code (eg 200, 404, ...)
 	status int
+	// response size in bytes, if reported by the parser
+	bytes int64
+	// request duration, if reported by the parser
+	duration time.Duration
+	// client address, if reported by the parser
+	remoteAddr string
+	// client user agent, if reported by the parser
+	userAgent string
+	// label identifying which -f entry this hit was read from, eg a
+	// file path or "-" for stdin
+	source string
 }
 
 type Logger interface {
@@ -38,31 +53,55 @@ type Logger interface {
 
 func main() {
 	var (
-		filename  = flag.String("f", "", "HTTP log file to monitor")
-		interval  = flag.Int("i", 10, "Interval at which statistics should be emitted")
-		window    = flag.Int("w", 120, "Alarm evaluation period")
-		threshold = flag.Int("t", 100, "Alarm threshold")
-		logger    = log.New(os.Stderr, "", log.LstdFlags)
+		filename         = flag.String("f", "", "HTTP log file(s) to monitor: comma-separated paths and/or globs, or - for stdin")
+		rescanInterval   = flag.Int("rescan-interval", 30, "How often (in seconds) to rescan -f for newly created files matching a glob; 0 disables rescanning")
+		groupBy          = flag.String("group-by", "", "Which single breakdown to print in the statistics: source, section or status; defaults to printing both section and status")
+		interval         = flag.Int("i", 10, "Interval at which statistics should be emitted")
+		threshold        = flag.Float64("t", 100, "Alarm trigger threshold (hits/sec EWMA)")
+		alarmLow         = flag.Float64("alarm-low", 0, "Alarm clear threshold (hits/sec EWMA), defaults to 80% of -t")
+		alarmTau         = flag.Float64("alarm-tau", 10, "Time constant (in seconds) of the alarm rate's EWMA smoothing")
+		alarmMinInterval = flag.Duration("alarm-min-interval", 30*time.Second, "Minimum time between two alarm state transitions")
+		format           = flag.String("format", "auto", "Log format to parse: auto, clf, json, logfmt or regex")
+		formatRegex      = flag.String("format-regex", "", "Custom regex used when -format=regex")
+		formatFields     = flag.String("format-fields", "", "Comma-separated hitfield=group mapping used when -format=regex, eg timestamp=ts,status=code")
+		webListenAddress = flag.String("web.listen-address", "", "Address to listen on for the Prometheus /metrics endpoint; disabled when empty")
+		webConfigFile    = flag.String("web.config.file", "", "Path to a web config file enabling TLS and/or basic auth for the /metrics endpoint")
+		alertmanagerURL  = flag.String("alertmanager.url", "", "Alertmanager base URL to which alarm transitions are forwarded as webhook alerts")
+		tsdbPath         = flag.String("tsdb.path", "", "Path to a file persisting hit history across restarts; disabled when empty")
+		tsdbRetention    = flag.Duration("tsdb.retention", 24*time.Hour, "How much history to keep in -tsdb.path when creating it; an existing file keeps its size")
+		logger           = log.New(os.Stderr, "", log.LstdFlags)
 	)
 	flag.Parse()
 	if *filename == "" {
 		log.Fatalln("-f argument is missing")
 	}
 
-	done := make(chan struct{})
-
-	logProcessor, err := NewLogProcessor(*filename, logger)
-	if err != nil {
+	newParser := func() (Parser, error) {
+		return newParserFromFlags(*format, *formatRegex, *formatFields)
+	}
+	if _, err := newParser(); err != nil {
 		logger.Fatalln(err)
 	}
 
-	// kick off the processing of the logs
+	done := make(chan struct{})
+
+	// kick off the processing of the logs: one goroutine per file
+	// matched by -f, multiplexed onto a single channel
 	hits := make(chan *Hit)
-	go logProcessor.Run(hits, done)
+	go runSources(strings.Split(*filename, ","), hits, done, newParser, time.Duration(*rescanInterval)*time.Second, logger)
+
+	var store *tsdb.DB
+	if *tsdbPath != "" {
+		var err error
+		store, err = tsdb.Open(*tsdbPath, *tsdbRetention)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+	}
 
 	// dispatch the hits to all the workers
-	statsWorker := NewStatsWorker(*interval, done, logger)
-	alarmWorker := NewAlarmWorker(*window, *threshold, done, logger)
+	statsWorker := NewStatsWorker(*interval, *groupBy, store, done, logger)
+	alarmWorker := NewAlarmWorker(*threshold, *alarmLow, *alarmTau, *alarmMinInterval, done, logger)
 	go func() {
 		for hit := range hits {
 			statsWorker.in <- hit
@@ -70,9 +109,36 @@ func main() {
 		}
 	}()
 
+	// optionally expose a Prometheus /metrics endpoint and forward
+	// alarm transitions to Alertmanager
+	if *webListenAddress != "" {
+		exporter := NewExporter(*webListenAddress, *webConfigFile, *alertmanagerURL, logger)
+		go exporter.Run(statsWorker.snapshot, alarmWorker.events, done)
+	}
+
 	// finally display the UI
-	go DrawUi(statsWorker.out, alarmWorker.out, done, logger)
+	go DrawUi(statsWorker.out, alarmWorker.out, store, done, logger)
 
 	// wait forever
 	<-done
 }
+
+// newParserFromFlags builds the Parser selected by -format, parsing
+// -format-regex/-format-fields when format is "regex".
+func newParserFromFlags(format, expr, fields string) (Parser, error) {
+	if format != "regex" {
+		return NewParser(format)
+	}
+
+	fieldMap := make(map[string]string)
+	if fields != "" {
+		for _, pair := range strings.Split(fields, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid -format-fields entry %q, expected hitfield=group", pair)
+			}
+			fieldMap[kv[0]] = kv[1]
+		}
+	}
+	return NewRegexParser(expr, fieldMap)
+}
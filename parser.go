@@ -0,0 +1,421 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parser turns a single raw log line into a Hit.
+type Parser interface {
+	Parse(line string) (*Hit, error)
+}
+
+// NewParser returns the Parser for the given format name. Supported
+// names are "clf", "json" and "logfmt". An empty name (or "auto")
+// returns a Parser that detects the format from the first non-empty
+// line it sees.
+func NewParser(format string) (Parser, error) {
+	switch format {
+	case "", "auto":
+		return &autoParser{}, nil
+	case "clf":
+		return NewCLFParser(), nil
+	case "json":
+		return NewJSONParser(nil), nil
+	case "logfmt":
+		return NewLogfmtParser(nil), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// autoParser detects the format of the first non-empty line it is
+// given and delegates every subsequent call to the matching Parser.
+type autoParser struct {
+	delegate Parser
+}
+
+func (a *autoParser) Parse(line string) (*Hit, error) {
+	if a.delegate == nil {
+		if strings.TrimSpace(line) == "" {
+			return nil, fmt.Errorf("empty line")
+		}
+		a.delegate = detectParser(line)
+	}
+	return a.delegate.Parse(line)
+}
+
+// detectParser guesses the format of a single log line.
+func detectParser(line string) Parser {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		return NewJSONParser(nil)
+	case clfRe.MatchString(trimmed):
+		return NewCLFParser()
+	default:
+		return NewLogfmtParser(nil)
+	}
+}
+
+// clfRe matches the request line of the Common/Combined Log Format,
+// eg `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif
+// HTTP/1.0" 200 2326`. It is shared by detectParser and CLFParser so
+// that a line recognised as CLF is guaranteed to also parse as one.
+var clfRe = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^]]+)\] "(\S+) (\S+) [^"]+" (\d+) (\S+)(?: "[^"]*" "([^"]*)")?`)
+
+// CLFParser parses Common and Combined Log Format lines.
+type CLFParser struct {
+	re *regexp.Regexp
+}
+
+// NewCLFParser returns a Parser for Apache/nginx Common and Combined
+// Log Format access logs.
+func NewCLFParser() *CLFParser {
+	// Combined Log Format adds the referer and user-agent fields
+	// after the status/bytes pair; both are optional.
+	return &CLFParser{re: clfRe}
+}
+
+func (p *CLFParser) Parse(line string) (*Hit, error) {
+	matches := p.re.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("no match found for %s", line)
+	}
+	timestamp, err := time.Parse("02/Jan/2006:15:04:05 -0700", matches[2])
+	if err != nil {
+		return nil, err
+	}
+	status, _ := strconv.Atoi(matches[5])
+	hit := &Hit{
+		timestamp:  timestamp,
+		remoteAddr: matches[1],
+		method:     matches[3],
+		uri:        matches[4],
+		status:     status,
+		userAgent:  matches[7],
+	}
+	if matches[6] != "-" {
+		if bytes, err := strconv.ParseInt(matches[6], 10, 64); err == nil {
+			hit.bytes = bytes
+		}
+	}
+	return hit, nil
+}
+
+// jsonFields lists, for every Hit field, the candidate JSON keys used
+// by common JSON access log emitters (nginx `escape=json`, Envoy,
+// Traefik) in order of preference.
+var jsonFields = map[string][]string{
+	"timestamp":   {"time", "@timestamp", "time_local", "start_time"},
+	"method":      {"method", "request_method"},
+	"uri":         {"uri", "path", "request_path", "RequestPath"},
+	"status":      {"status", "status_code", "DownstreamStatus"},
+	"bytes":       {"bytes", "bytes_sent", "body_bytes_sent", "DownstreamContentSize"},
+	"duration":    {"duration", "request_time", "Duration"},
+	"remote_addr": {"remote_addr", "client_ip", "downstream_remote_address", "ClientHost"},
+	"user_agent":  {"user_agent", "http_user_agent", "RequestUserAgent"},
+}
+
+// JSONParser parses one JSON object per line, as emitted by nginx's
+// `log_format ... escape=json`, Envoy and Traefik JSON access logs.
+type JSONParser struct {
+	// fields maps a Hit field name to the JSON key(s) that may carry
+	// it; it defaults to jsonFields and can be overridden to support
+	// custom log formats.
+	fields map[string][]string
+}
+
+// NewJSONParser returns a Parser for JSON access log lines. A nil
+// fields map uses the built-in mapping covering nginx, Envoy and
+// Traefik.
+func NewJSONParser(fields map[string][]string) *JSONParser {
+	if fields == nil {
+		fields = jsonFields
+	}
+	return &JSONParser{fields: fields}
+}
+
+func (p *JSONParser) Parse(line string) (*Hit, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, err
+	}
+
+	get := func(field string) (interface{}, bool) {
+		for _, key := range p.fields[field] {
+			if v, ok := raw[key]; ok {
+				return v, true
+			}
+		}
+		return nil, false
+	}
+
+	hit := &Hit{}
+	populateHit(hit, get)
+	return hit, nil
+}
+
+// populateHit fills in the fields of hit that get reports present,
+// using get to fetch the raw value for each Hit field name
+// (timestamp, method, uri, status, bytes, duration, remote_addr,
+// user_agent). It is shared by JSONParser, LogfmtParser and
+// RegexParser so the field-by-field assignment logic lives in one
+// place.
+func populateHit(hit *Hit, get func(field string) (interface{}, bool)) {
+	if v, ok := get("timestamp"); ok {
+		if ts, err := parseTimestamp(v); err == nil {
+			hit.timestamp = ts
+		}
+	}
+	if v, ok := get("method"); ok {
+		hit.method, _ = v.(string)
+	}
+	if v, ok := get("uri"); ok {
+		hit.uri, _ = v.(string)
+	}
+	if v, ok := get("status"); ok {
+		hit.status = toInt(v)
+	}
+	if v, ok := get("bytes"); ok {
+		hit.bytes = int64(toInt(v))
+	}
+	if v, ok := get("duration"); ok {
+		hit.duration = toDuration(v)
+	}
+	if v, ok := get("remote_addr"); ok {
+		hit.remoteAddr, _ = v.(string)
+	}
+	if v, ok := get("user_agent"); ok {
+		hit.userAgent, _ = v.(string)
+	}
+}
+
+// logfmtFields lists, for every Hit field, the candidate logfmt keys
+// used by common logfmt-style emitters (eg Caddy, Heroku router).
+var logfmtFields = map[string][]string{
+	"timestamp":   {"ts", "time"},
+	"method":      {"method"},
+	"uri":         {"uri", "path"},
+	"status":      {"status"},
+	"bytes":       {"bytes", "size"},
+	"duration":    {"duration", "dur"},
+	"remote_addr": {"remote_addr", "fwd"},
+	"user_agent":  {"user_agent", "useragent"},
+}
+
+// LogfmtParser parses `key=value` logfmt lines.
+type LogfmtParser struct {
+	fields map[string][]string
+}
+
+// NewLogfmtParser returns a Parser for logfmt-encoded access log
+// lines. A nil fields map uses the built-in mapping.
+func NewLogfmtParser(fields map[string][]string) *LogfmtParser {
+	if fields == nil {
+		fields = logfmtFields
+	}
+	return &LogfmtParser{fields: fields}
+}
+
+func (p *LogfmtParser) Parse(line string) (*Hit, error) {
+	raw, err := decodeLogfmt(line)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(field string) (string, bool) {
+		for _, key := range p.fields[field] {
+			if v, ok := raw[key]; ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+
+	hit := &Hit{}
+	populateHit(hit, stringGetter(get))
+	return hit, nil
+}
+
+// decodeLogfmt splits a logfmt line into its key/value pairs. Values
+// may be bare words or double-quoted strings.
+func decodeLogfmt(line string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			break
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			break
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, "\"") {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted value for key %q", key)
+			}
+			value = line[1 : 1+end]
+			line = line[1+end+1:]
+		} else {
+			end := strings.IndexByte(line, ' ')
+			if end < 0 {
+				value, line = line, ""
+			} else {
+				value, line = line[:end], line[end:]
+			}
+		}
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+// NewRegexParser returns a Parser driven by a user-supplied regex and
+// a field-name mapping, for servers whose log format doesn't match
+// any of the built-in parsers. fields maps Hit field names
+// (timestamp, method, uri, status, bytes, duration, remote_addr,
+// user_agent) to the name of the regex's matching capture group;
+// fields with no entry are left unset.
+func NewRegexParser(expr string, fields map[string]string) (*RegexParser, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	names := re.SubexpNames()
+	for _, group := range fields {
+		found := false
+		for _, name := range names {
+			if name == group {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("regex has no capture group named %q", group)
+		}
+	}
+	return &RegexParser{re: re, fields: fields}, nil
+}
+
+// RegexParser parses lines using a custom regex and field mapping.
+type RegexParser struct {
+	re     *regexp.Regexp
+	fields map[string]string
+}
+
+func (p *RegexParser) Parse(line string) (*Hit, error) {
+	matches := p.re.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("no match found for %s", line)
+	}
+
+	get := func(field string) (string, bool) {
+		group, ok := p.fields[field]
+		if !ok {
+			return "", false
+		}
+		for i, name := range p.re.SubexpNames() {
+			if name == group && i < len(matches) {
+				return matches[i], true
+			}
+		}
+		return "", false
+	}
+
+	hit := &Hit{}
+	populateHit(hit, stringGetter(get))
+	return hit, nil
+}
+
+// stringGetter adapts a (string, bool) field getter, as used by
+// LogfmtParser and RegexParser, to the (interface{}, bool) signature
+// populateHit expects.
+func stringGetter(get func(field string) (string, bool)) func(string) (interface{}, bool) {
+	return func(field string) (interface{}, bool) {
+		v, ok := get(field)
+		return v, ok
+	}
+}
+
+// parseTimestamp accepts a RFC3339 string, a CLF-style string (eg
+// "10/Oct/2000:13:55:36 -0700") or a numeric Unix timestamp (seconds,
+// optionally fractional, as a number or a string).
+func parseTimestamp(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case string:
+		if ts, err := time.Parse(time.RFC3339, t); err == nil {
+			return ts, nil
+		}
+		if ts, err := time.Parse("02/Jan/2006:15:04:05 -0700", t); err == nil {
+			return ts, nil
+		}
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return unixToTime(f), nil
+		}
+		return time.Time{}, fmt.Errorf("unrecognized timestamp %q", t)
+	case float64:
+		return unixToTime(t), nil
+	default:
+		return time.Time{}, fmt.Errorf("unrecognized timestamp %v", v)
+	}
+}
+
+func unixToTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	default:
+		return 0
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+// toDuration converts a raw duration value to a time.Duration. String
+// values may be a Go duration literal (eg "150ms") or a bare number of
+// seconds; anything else is treated as a number of seconds.
+func toDuration(v interface{}) time.Duration {
+	if s, ok := v.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d
+		}
+	}
+	return time.Duration(toFloat(v) * float64(time.Second))
+}
@@ -0,0 +1,101 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package main
+
+import (
+	"testing"
+)
+
+func TestCLFParser(t *testing.T) {
+	p := NewCLFParser()
+	line := `127.0.0.1 - james [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123 "-" "curl/7.54.0"`
+
+	hit, err := p.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit.method != "GET" || hit.uri != "/report" || hit.status != 200 {
+		t.Fatalf("unexpected hit: %+v", hit)
+	}
+	if hit.bytes != 123 {
+		t.Fatalf("expected 123 bytes but got %d", hit.bytes)
+	}
+	if hit.remoteAddr != "127.0.0.1" {
+		t.Fatalf("expected remote addr 127.0.0.1 but got %s", hit.remoteAddr)
+	}
+	if hit.userAgent != "curl/7.54.0" {
+		t.Fatalf("expected user agent curl/7.54.0 but got %s", hit.userAgent)
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	p := NewJSONParser(nil)
+	line := `{"time":"2018-05-09T16:00:39Z","method":"GET","uri":"/report","status":200,"bytes_sent":123}`
+
+	hit, err := p.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit.method != "GET" || hit.uri != "/report" || hit.status != 200 || hit.bytes != 123 {
+		t.Fatalf("unexpected hit: %+v", hit)
+	}
+}
+
+func TestLogfmtParser(t *testing.T) {
+	p := NewLogfmtParser(nil)
+	line := `method=GET path=/report status=200 bytes=123 duration=0.042`
+
+	hit, err := p.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit.method != "GET" || hit.uri != "/report" || hit.status != 200 || hit.bytes != 123 {
+		t.Fatalf("unexpected hit: %+v", hit)
+	}
+}
+
+func TestRegexParser(t *testing.T) {
+	p, err := NewRegexParser(`^(?P<verb>\S+) (?P<path>\S+) (?P<code>\d+)$`, map[string]string{
+		"method": "verb",
+		"uri":    "path",
+		"status": "code",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hit, err := p.Parse("GET /report 200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit.method != "GET" || hit.uri != "/report" || hit.status != 200 {
+		t.Fatalf("unexpected hit: %+v", hit)
+	}
+
+	if _, err := NewRegexParser(`^(?P<verb>\S+)$`, map[string]string{"method": "missing"}); err == nil {
+		t.Fatal("expected error for unknown capture group")
+	}
+}
+
+func TestAutoParser(t *testing.T) {
+	a := &autoParser{}
+	hit, err := a.Parse(`{"method":"GET","uri":"/report","status":200}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit.method != "GET" {
+		t.Fatalf("unexpected hit: %+v", hit)
+	}
+	if _, ok := a.delegate.(*JSONParser); !ok {
+		t.Fatalf("expected JSONParser to be detected, got %T", a.delegate)
+	}
+}
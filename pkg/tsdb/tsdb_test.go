@@ -0,0 +1,102 @@
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+package tsdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOffsetNegativeWraparound(t *testing.T) {
+	db := &DB{capacity: 10}
+
+	if got := db.offset(5); got != headerSize+5*recordSize {
+		t.Fatalf("offset(5) = %d, want %d", got, headerSize+5*recordSize)
+	}
+	// a negative unix-second % capacity must still land on a valid,
+	// non-negative slot rather than Go's negative-modulo result
+	if got := db.offset(-1); got != headerSize+9*recordSize {
+		t.Fatalf("offset(-1) = %d, want %d", got, headerSize+9*recordSize)
+	}
+	if got := db.offset(-10); got != headerSize+0*recordSize {
+		t.Fatalf("offset(-10) = %d, want %d", got, headerSize)
+	}
+}
+
+func TestOpenHeaderRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.db")
+
+	db, err := Open(path, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.capacity != 10 {
+		t.Fatalf("expected capacity 10, got %d", db.capacity)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening an existing file must read the capacity back from the
+	// header rather than resizing it to match the (possibly different)
+	// retention passed in
+	reopened, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.capacity != 10 {
+		t.Fatalf("expected capacity preserved at 10 after reopen, got %d", reopened.capacity)
+	}
+}
+
+func TestWriteRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hits.db")
+	db, err := Open(path, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	base := time.Unix(1000, 0)
+	if err := db.Write(base, 3, []int{0, 1, 2, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Write(base.Add(time.Second), 4, []int{0, 0, 4, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := db.Range(base, base.Add(2*time.Second), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(buckets))
+	}
+	if buckets[0].Total != 7 {
+		t.Fatalf("expected total 7, got %d", buckets[0].Total)
+	}
+	if buckets[0].Status[2] != 6 {
+		t.Fatalf("expected status class 2 count 6, got %d", buckets[0].Status[2])
+	}
+
+	// a second that was never written counts as zero rather than
+	// erroring out
+	empty, err := db.Range(base.Add(100*time.Second), base.Add(101*time.Second), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty) != 1 || empty[0].Total != 0 {
+		t.Fatalf("expected a single empty bucket, got %+v", empty)
+	}
+}
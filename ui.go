@@ -12,11 +12,22 @@
 package main
 
 import (
+	"time"
+
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
+
+	"github.com/simonpasquier/http_log_console/pkg/tsdb"
 )
 
-func DrawUi(stat chan []string, alert chan string, done chan struct{}, logger Logger) error {
+// historyPoints is the number of steps shown in the history sparkline.
+const historyPoints = 60
+
+// historyStep is the width of a single point in the history
+// sparkline; 'h'/'l' scroll the visible window by this much.
+const historyStep = time.Minute
+
+func DrawUi(stat chan []string, alert chan string, store *tsdb.DB, done chan struct{}, logger Logger) error {
 	if err := ui.Init(); err != nil {
 		return err
 	}
@@ -30,19 +41,63 @@ func DrawUi(stat chan []string, alert chan string, done chan struct{}, logger Lo
 	al := widgets.NewList()
 	al.Rows = []string{}
 	al.Title = "Alerts"
-	al.SetRect(41, 0, 81, 120)
+	al.SetRect(41, 0, 81, 40)
+
+	hs := widgets.NewSparkline()
+	hs.Data = []float64{0}
+	hg := widgets.NewSparklineGroup(hs)
+	hg.Title = "History (total hits/min)"
+	hg.SetRect(0, 41, 81, 55)
+
+	drawables := []ui.Drawable{sl, al}
+	if store != nil {
+		drawables = append(drawables, hg)
+	}
+	ui.Render(drawables...)
+
+	// windowEnd is the right edge of the history sparkline's visible
+	// window; 'h'/'l' scroll it into the past/future.
+	windowEnd := time.Now()
+	refreshHistory := func() {
+		if store == nil {
+			return
+		}
+		from := windowEnd.Add(-historyPoints * historyStep)
+		buckets, err := store.Range(from, windowEnd, historyStep)
+		if err != nil {
+			logger.Println(err)
+			return
+		}
+		data := make([]float64, len(buckets))
+		for i, b := range buckets {
+			data[i] = float64(b.Total)
+		}
+		if len(data) == 0 {
+			data = []float64{0}
+		}
+		hs.Data = data
+		ui.Render(hg)
+	}
 
-	ui.Render(sl, al)
+	// scroll carries 'h'/'l' keypresses from the UI-event loop below to
+	// the goroutine that owns windowEnd and the sparkline widget, so
+	// that state is only ever touched from one goroutine.
+	scroll := make(chan time.Duration)
 
 	go func() {
 		for {
 			select {
 			case stats := <-stat:
 				sl.Rows = stats
-				ui.Render(sl, al)
+				ui.Render(sl)
+				windowEnd = time.Now()
+				refreshHistory()
 			case alert := <-alert:
 				al.Rows = append([]string{alert}, al.Rows...)
-				ui.Render(sl, al)
+				ui.Render(al)
+			case d := <-scroll:
+				windowEnd = windowEnd.Add(d)
+				refreshHistory()
 			case <-done:
 				return
 			}
@@ -56,6 +111,10 @@ func DrawUi(stat chan []string, alert chan string, done chan struct{}, logger Lo
 		case "q", "<C-c>":
 			close(done)
 			return nil
+		case "h":
+			scroll <- -historyStep
+		case "l":
+			scroll <- historyStep
 		}
 	}
 }
@@ -13,11 +13,13 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"sort"
 	"time"
 
 	"github.com/simonpasquier/http_log_console/pkg/atime"
+	"github.com/simonpasquier/http_log_console/pkg/tsdb"
 )
 
 // the following code comes from [1] because Golang has no built-in function
@@ -48,6 +50,28 @@ func sortMapByValue(m map[string]int) PairList {
 	return p
 }
 
+// StatsSnapshot is a structured view of the stats collected during one
+// interval, for consumers that need raw numbers instead of the
+// pre-formatted strings sent on StatsWorker.out (eg a metrics exporter).
+type StatsSnapshot struct {
+	// interval (in seconds) the snapshot covers
+	Interval int
+	// total number of hits
+	TotalHits int
+	// the number of hits broken down by section
+	SectionHits map[string]int
+	// the number of hits broken down by status class, index 0 is
+	// "other" and indices 1-5 are the 1xx-5xx classes
+	StatusHits []int
+	// the number of hits broken down by section, then by status class
+	// using the same indexing as StatusHits; this is what feeds the
+	// per-section "http_log_hits_total" metric
+	SectionStatusHits map[string][]int
+	// the number of hits broken down by source, populated whatever
+	// groupBy StatsWorker was created with
+	SourceHits map[string]int
+}
+
 // StatsWorker aggregates the number of hits over the given interval
 type StatsWorker struct {
 	// total number of hits
@@ -56,37 +80,81 @@ type StatsWorker struct {
 	statusHits []int
 	// the number of hits broken down by section
 	sectionHits map[string]int
+	// the number of hits broken down by section, then by status class,
+	// using the same indexing as statusHits
+	sectionStatusHits map[string][]int
+	// the number of hits broken down by source
+	sourceHits map[string]int
+	// which single dimension, if any, the emitted stats text is broken
+	// down by: "source", "section" or "status"; empty prints both the
+	// section and status breakdowns, matching the pre-"-group-by"
+	// behavior
+	groupBy string
 	// interval (in seconds) at which statistics are emitted
 	interval int
 	// channel for receiving the Hit values
 	in chan *Hit
 	// channel for sending out the statistics
 	out chan []string
+	// channel for sending out a structured snapshot of the same
+	// statistics, for non-UI consumers such as the metrics exporter
+	snapshot chan StatsSnapshot
+	// optional persistent store recording each tick's total and
+	// per-status-class counts, so history survives a restart
+	store *tsdb.DB
 	// channel indicating that the application is done
 	done   <-chan struct{}
 	logger Logger
 }
 
-// Returns a new instance of StatsWorker
-func NewStatsWorker(interval int, done <-chan struct{}, logger Logger) *StatsWorker {
+// Returns a new instance of StatsWorker. groupBy selects which single
+// breakdown ("source", "section" or "status") the emitted stats text
+// is printed with; any other value prints both the section and status
+// breakdowns. The structured StatsSnapshot sent to snapshot always
+// carries every breakdown regardless of groupBy. store may be nil to
+// disable historical persistence.
+func NewStatsWorker(interval int, groupBy string, store *tsdb.DB, done <-chan struct{}, logger Logger) *StatsWorker {
 	in := make(chan *Hit)
 	out := make(chan []string)
+	// buffered and never required to have a reader: the metrics
+	// exporter is optional, so publishing a snapshot must never block
+	// the worker
+	snapshot := make(chan StatsSnapshot, 1)
 
 	s := StatsWorker{
-		logger:      logger,
-		sectionHits: make(map[string]int),
-		statusHits:  make([]int, 6),
-		interval:    interval,
-		in:          in,
-		out:         out,
-		done:        done,
+		logger:            logger,
+		sectionHits:       make(map[string]int),
+		sectionStatusHits: make(map[string][]int),
+		sourceHits:        make(map[string]int),
+		statusHits:        make([]int, 6),
+		groupBy:           groupBy,
+		interval:          interval,
+		in:                in,
+		out:               out,
+		snapshot:          snapshot,
+		store:             store,
+		done:              done,
 	}
 
 	go func() {
 		defer close(out)
+		defer close(snapshot)
 		ticker := time.NewTicker(time.Second * time.Duration(s.interval))
 		defer ticker.Stop()
 		section := regexp.MustCompile("^(?:/([^/]+)/)")
+
+		// the persistent store keeps one record per second, so it is
+		// fed from a ticker of its own rather than the (usually
+		// coarser) display interval above
+		secTotal := 0
+		secStatus := make([]int, 6)
+		var persistTick <-chan time.Time
+		if s.store != nil {
+			persistTicker := time.NewTicker(time.Second)
+			defer persistTicker.Stop()
+			persistTick = persistTicker.C
+		}
+
 		for {
 			select {
 			case hit := <-s.in:
@@ -98,23 +166,79 @@ func NewStatsWorker(interval int, done <-chan struct{}, logger Logger) *StatsWor
 				status := hit.status / 100
 				if status >= 0 && status < len(s.statusHits) {
 					s.statusHits[status] += 1
+					if s.sectionStatusHits[skey] == nil {
+						s.sectionStatusHits[skey] = make([]int, len(s.statusHits))
+					}
+					s.sectionStatusHits[skey][status] += 1
 				}
+				s.sourceHits[hit.source] += 1
 				s.totalHits += 1
+				secTotal += 1
+				if status >= 0 && status < len(secStatus) {
+					secStatus[status] += 1
+				}
+			case <-persistTick:
+				if err := s.store.Write(time.Now(), secTotal, secStatus); err != nil {
+					s.logger.Println(err)
+				}
+				secTotal = 0
+				for i := range secStatus {
+					secStatus[i] = 0
+				}
 			case <-ticker.C:
+				snap := StatsSnapshot{
+					Interval:          s.interval,
+					TotalHits:         s.totalHits,
+					SectionHits:       make(map[string]int, len(s.sectionHits)),
+					StatusHits:        append([]int(nil), s.statusHits...),
+					SectionStatusHits: make(map[string][]int, len(s.sectionStatusHits)),
+					SourceHits:        make(map[string]int, len(s.sourceHits)),
+				}
+
+				// groupBy selects which single breakdown is printed
+				// alongside the total, defaulting to both section and
+				// status when unset; the snapshot sent to the metrics
+				// exporter always carries every breakdown regardless.
+				showSection := s.groupBy != "source" && s.groupBy != "status"
+				showStatus := s.groupBy != "source" && s.groupBy != "section"
+
 				stats := make([]string, 0)
+				if s.groupBy == "source" {
+					for _, p := range sortMapByValue(s.sourceHits) {
+						stats = append(stats, fmt.Sprintf("'%s' source: %d hits", p.Key, p.Value))
+					}
+				}
 				for _, p := range sortMapByValue(s.sectionHits) {
-					stats = append(stats, fmt.Sprintf("'%s' section: %d hits", p.Key, p.Value))
+					if showSection {
+						stats = append(stats, fmt.Sprintf("'%s' section: %d hits", p.Key, p.Value))
+					}
+					snap.SectionHits[p.Key] = p.Value
 					delete(s.sectionHits, p.Key)
 				}
+				for k, v := range s.sectionStatusHits {
+					snap.SectionStatusHits[k] = append([]int(nil), v...)
+					delete(s.sectionStatusHits, k)
+				}
 				for i, v := range s.statusHits[1:] {
-					stats = append(stats, fmt.Sprintf("'%dxx': %d hits", i+1, v))
+					if showStatus {
+						stats = append(stats, fmt.Sprintf("'%dxx': %d hits", i+1, v))
+					}
 					s.statusHits[i] = 0
 				}
-				stats = append(stats, fmt.Sprintf("'other': %d hits", s.statusHits[0]))
+				if showStatus {
+					stats = append(stats, fmt.Sprintf("'other': %d hits", s.statusHits[0]))
+				}
 				s.statusHits[0] = 0
 				stats = append(stats, fmt.Sprintf("total: %d hits (%.02f/sec)", s.totalHits, float64(s.totalHits)/float64(s.interval)))
 				s.totalHits = 0
+
+				for k, v := range s.sourceHits {
+					snap.SourceHits[k] = v
+					delete(s.sourceHits, k)
+				}
+
 				out <- stats
+				publishSnapshot(snapshot, snap)
 			case <-done:
 				s.logger.Println("Exiting StatsWorker")
 				return
@@ -125,6 +249,27 @@ func NewStatsWorker(interval int, done <-chan struct{}, logger Logger) *StatsWor
 	return &s
 }
 
+// publishSnapshot sends snap on the buffered(1) snapshot channel
+// without blocking. If a stale, not-yet-read snapshot is already
+// sitting in the channel it is replaced rather than dropping the new
+// one, so a slow consumer (eg the metrics exporter) always observes
+// the most recent tick once it catches up.
+func publishSnapshot(ch chan StatsSnapshot, snap StatsSnapshot) {
+	select {
+	case ch <- snap:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- snap:
+	default:
+	}
+}
+
 type Clocker interface {
 	Now() uint64
 }
@@ -192,41 +337,102 @@ func (c *CircularCounter) Sum() int {
 	return sum
 }
 
-// AlertWorker detects if the hits count crosses a predefined threshold and
-// emits alerts when it is the case
+// LastBucket returns the value accumulated during the current
+// 1-second bucket.
+func (c *CircularCounter) LastBucket() int {
+	c.Forward()
+	return c.buckets[c.currentIndex]
+}
+
+// ConsumeCurrent returns the value accumulated in the bucket that was
+// current before advancing time, then lets Forward roll the counter
+// forward as usual. Unlike LastBucket, the value is captured before
+// Forward's own clearing pass can reach it, so it stays correct even
+// with a single-bucket (window=1) counter, where the bucket Forward
+// clears on rollover and the one just read are the same slot.
+func (c *CircularCounter) ConsumeCurrent() int {
+	val := c.buckets[c.currentIndex]
+	c.Forward()
+	return val
+}
+
+// AlarmEvent is a structured view of an alarm state transition, for
+// consumers that need more than the formatted text sent on
+// AlarmWorker.out (eg a metrics exporter or an Alertmanager webhook).
+type AlarmEvent struct {
+	Triggered bool
+	EMA       float64
+	At        time.Time
+}
+
+// AlarmWorker detects if the hits rate crosses a predefined threshold and
+// emits alerts when it is the case. The threshold check runs against an
+// exponentially-weighted moving average of the per-second hit rate rather
+// than the raw count, and uses separate trigger/clear thresholds
+// (hysteresis) plus a minimum re-arm interval so that a bursty but
+// borderline rate doesn't flap between the two states.
 type AlarmWorker struct {
 	// stores the number of hits per second
 	counter *CircularCounter
-	// threshold value
-	threshold int
+	// exponentially-weighted moving average of the per-second rate
+	ema float64
+	// smoothing factor applied to each new sample, derived from the
+	// alarm-tau time constant
+	alpha float64
+	// rate at or above which the alarm triggers
+	highThreshold float64
+	// rate at or below which a triggered alarm clears
+	lowThreshold float64
+	// minimum duration between two state transitions
+	minInterval time.Duration
 	// whether the alert has been triggered or not
 	triggered bool
+	// when the alert last changed state
+	lastTransition time.Time
 	// channel for receiving the Hit values
 	in chan *Hit
 	// channel for sending out the alerts
 	out chan string
+	// channel for sending out a structured view of the same
+	// transitions, for non-UI consumers such as the metrics exporter
+	events chan AlarmEvent
 	// channel indicating that the application is done
 	done   <-chan struct{}
 	logger Logger
 }
 
-// Returns a new instance of AlarmWorker
-func NewAlarmWorker(window int, threshold int, done <-chan struct{}, logger Logger) *AlarmWorker {
+// Returns a new instance of AlarmWorker. lowThreshold defaults to 80% of
+// highThreshold when zero or negative.
+func NewAlarmWorker(highThreshold, lowThreshold, tau float64, minInterval time.Duration, done <-chan struct{}, logger Logger) *AlarmWorker {
 	in := make(chan *Hit)
 	out := make(chan string)
+	// buffered and never required to have a reader, see
+	// StatsWorker.snapshot
+	events := make(chan AlarmEvent, 1)
+
+	if lowThreshold <= 0 {
+		lowThreshold = 0.8 * highThreshold
+	}
 
 	a := AlarmWorker{
-		logger:    logger,
-		counter:   NewCircularCounter(window, nil),
-		threshold: threshold,
-		triggered: false,
-		in:        in,
-		out:       out,
-		done:      done,
+		logger: logger,
+		// the alarm only ever reads the current 1s bucket (see the
+		// ticker case below), so a single-bucket counter is enough.
+		counter:       NewCircularCounter(1, nil),
+		alpha:         1 - math.Exp(-1/tau),
+		highThreshold: highThreshold,
+		lowThreshold:  lowThreshold,
+		minInterval:   minInterval,
+		triggered:     false,
+		in:            in,
+		out:           out,
+		events:        events,
+		done:          done,
 	}
 
 	go func() {
 		defer close(out)
+		defer close(events)
 		ticker := time.NewTicker(time.Second * time.Duration(1))
 		defer ticker.Stop()
 		for {
@@ -234,20 +440,29 @@ func NewAlarmWorker(window int, threshold int, done <-chan struct{}, logger Logg
 			case <-a.in:
 				a.counter.Add(1)
 			case <-ticker.C:
-				// clean up buffer
-				sum := a.counter.Sum()
-				if !a.triggered && sum >= a.threshold {
+				sample := float64(a.counter.ConsumeCurrent())
+				a.ema = a.alpha*sample + (1-a.alpha)*a.ema
+
+				now := time.Now()
+				if now.Sub(a.lastTransition) < a.minInterval {
+					continue
+				}
+				if !a.triggered && a.ema >= a.highThreshold {
 					out <- fmt.Sprintf(
-						"High traffic generated an alert - hits = %d, triggered at %s",
-						sum,
-						time.Now().Format(time.RFC3339))
+						"High traffic generated an alert - hits = %.2f, triggered at %s",
+						a.ema,
+						now.Format(time.RFC3339))
 					a.triggered = true
-				} else if a.triggered && sum < a.threshold {
+					a.lastTransition = now
+					a.publish(events)
+				} else if a.triggered && a.ema <= a.lowThreshold {
 					out <- fmt.Sprintf(
-						"Traffic went back to normal - hits = %d, triggered at %s",
-						sum,
-						time.Now().Format(time.RFC3339))
+						"Traffic went back to normal - hits = %.2f, triggered at %s",
+						a.ema,
+						now.Format(time.RFC3339))
 					a.triggered = false
+					a.lastTransition = now
+					a.publish(events)
 				}
 			case <-done:
 				a.logger.Println("Exiting AlarmWorker")
@@ -258,3 +473,26 @@ func NewAlarmWorker(window int, threshold int, done <-chan struct{}, logger Logg
 
 	return &a
 }
+
+// publish emits the current state as an AlarmEvent without blocking.
+// If a stale, not-yet-read event is already sitting in the buffered(1)
+// channel it is replaced rather than dropping the new one, so a slow
+// consumer (eg the metrics exporter POSTing to Alertmanager) always
+// observes the latest state; an older transition it has not read yet
+// is discarded.
+func (a *AlarmWorker) publish(events chan AlarmEvent) {
+	event := AlarmEvent{Triggered: a.triggered, EMA: a.ema, At: a.lastTransition}
+	select {
+	case events <- event:
+		return
+	default:
+	}
+	select {
+	case <-events:
+	default:
+	}
+	select {
+	case events <- event:
+	default:
+	}
+}
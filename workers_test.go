@@ -73,20 +73,56 @@ func TestCircularCounterSum(t *testing.T) {
 	}
 }
 
+func TestCircularCounterLastBucket(t *testing.T) {
+	clocker := FakeClocker{}
+	counter := NewCircularCounter(120, &clocker)
+
+	counter.Add(1)
+	counter.Add(1)
+	v := counter.LastBucket()
+	if v != 2 {
+		t.Fatalf("Expected 2 but got %d", v)
+	}
+
+	clocker.Set(1)
+	v = counter.LastBucket()
+	if v != 0 {
+		t.Fatalf("Expected 0 but got %d", v)
+	}
+}
+
+func TestCircularCounterConsumeCurrentWindowOne(t *testing.T) {
+	// window=1 is what AlarmWorker actually uses: the bucket Forward
+	// clears on rollover and the one holding this period's count are
+	// the same slot, so a plain LastBucket() would always read back 0.
+	clocker := FakeClocker{}
+	counter := NewCircularCounter(1, &clocker)
+
+	counter.Add(1)
+	counter.Add(1)
+
+	clocker.Set(1)
+	v := counter.ConsumeCurrent()
+	if v != 2 {
+		t.Fatalf("Expected 2 but got %d", v)
+	}
+
+	// the period just consumed must not leak into the next one
+	v = counter.ConsumeCurrent()
+	if v != 0 {
+		t.Fatalf("Expected 0 but got %d", v)
+	}
+}
+
 func TestAlarmWorker(t *testing.T) {
 	logger := log.New(ioutil.Discard, "", log.LstdFlags)
 	done := make(chan struct{})
 	defer close(done)
-	worker := NewAlarmWorker(5, 2, done, logger)
+	// A tiny tau makes the EWMA track the raw per-second sample almost
+	// exactly, so the test can reason in terms of hits/sec like before.
+	worker := NewAlarmWorker(2, 0, 0.001, 0, done, logger)
 
 	worker.in <- &Hit{}
-	select {
-	case <-worker.out:
-		t.Fatal("Expected 0 alert but got 1")
-	case <-time.After(2 * time.Second):
-		break
-	}
-
 	worker.in <- &Hit{}
 	select {
 	case alert := <-worker.out:
@@ -104,7 +140,7 @@ func TestAlarmWorker(t *testing.T) {
 			t.Fatalf("Expected alert with 'back to normal' but got '%s'", alert)
 		}
 		break
-	case <-time.After(4 * time.Second):
+	case <-time.After(2 * time.Second):
 		t.Fatal("Expected 1 alert but got 0")
 	}
 }